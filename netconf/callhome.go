@@ -0,0 +1,193 @@
+package netconf
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// semBoundSession wraps a Session so that releasing the CallHomeListener's
+// concurrency-bounding semaphore slot happens on Close, not on handoff.
+// Without this, the slot held by handle would be released as soon as the
+// session is handed off on Sessions(), and maxConcurrent would bound only
+// concurrent accepts/handshakes rather than concurrent live sessions.
+type semBoundSession struct {
+	Session
+	release func()
+	once    sync.Once
+}
+
+func (s *semBoundSession) Close() {
+	s.once.Do(s.release)
+	s.Session.Close()
+}
+
+// CallHomeFactory turns a just-accepted net.Conn into a netconf Transport,
+// alongside a fingerprint identifying the peer device (e.g. the SHA-256 of
+// its TLS client certificate, or of its SSH host key). The fingerprint is
+// looked up in a Registry to resolve a logical device name. Implementations
+// live alongside whatever transport package does the TLS or SSH handshake;
+// CallHomeListener itself is transport-agnostic.
+type CallHomeFactory func(conn net.Conn) (t Transport, fingerprint string, err error)
+
+// Registry maps device fingerprints (TLS client certificate or SSH host key,
+// typically a hex SHA-256 digest) to logical device names, for devices
+// expected to call home.
+type Registry struct {
+	mu      sync.Mutex
+	devices map[string]string
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{devices: make(map[string]string)}
+}
+
+// Add registers name under fingerprint.
+func (r *Registry) Add(fingerprint, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.devices[fingerprint] = name
+}
+
+// Lookup returns the device name registered for fingerprint, if any.
+func (r *Registry) Lookup(fingerprint string) (name string, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name, ok = r.devices[fingerprint]
+	return
+}
+
+// CallHomeListener implements the collector side of NETCONF Call Home
+// (RFC 8071): rather than the client dialing the device, the device dials
+// the collector, which accepts the connection, identifies the device, and
+// hands off a ready Session on Sessions().
+type CallHomeListener struct {
+	ln       net.Listener
+	factory  CallHomeFactory
+	registry *Registry
+	evtlog   *log.Logger
+	nclog    *log.Logger
+
+	sessions chan Session
+	sem      chan struct{} // bounds concurrent call-home sessions
+
+	perSource  time.Duration // minimum interval between accepted connections from the same source
+	lastSeen   map[string]time.Time
+	lastSeenMu sync.Mutex
+}
+
+// NewCallHomeListener wraps an already-bound ln (typically a TLS or SSH
+// listener) as a Call Home collector. factory completes the transport
+// handshake and identifies the peer; registry resolves identities to device
+// names; maxConcurrent bounds simultaneous in-flight call-home sessions;
+// perSource rate-limits repeat connection attempts from the same remote
+// address (0 disables rate limiting).
+func NewCallHomeListener(ln net.Listener, factory CallHomeFactory, registry *Registry, maxConcurrent int, perSource time.Duration, evtlog, nclog *log.Logger) *CallHomeListener {
+	return &CallHomeListener{
+		ln:        ln,
+		factory:   factory,
+		registry:  registry,
+		evtlog:    evtlog,
+		nclog:     nclog,
+		sessions:  make(chan Session),
+		sem:       make(chan struct{}, maxConcurrent),
+		perSource: perSource,
+		lastSeen:  make(map[string]time.Time),
+	}
+}
+
+// Sessions returns the channel on which ready Sessions are delivered, one per
+// accepted and successfully negotiated call-home connection.
+func (l *CallHomeListener) Sessions() <-chan Session {
+	return l.sessions
+}
+
+// Serve accepts inbound connections until ln is closed, handing each off to a
+// goroutine that applies rate limiting and the concurrency bound before
+// negotiating a Session. It returns the error that stopped accepting, which
+// is expected to be non-nil (typically from Close).
+func (l *CallHomeListener) Serve() error {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			return err
+		}
+		go l.handle(conn)
+	}
+}
+
+// Close stops accepting new connections. Sessions already delivered on
+// Sessions() are unaffected.
+func (l *CallHomeListener) Close() error {
+	return l.ln.Close()
+}
+
+func (l *CallHomeListener) handle(conn net.Conn) {
+	source := sourceHost(conn)
+
+	if !l.allowSource(source) {
+		l.evtlog.Printf("call-home: rate-limited connection from %s\n", source)
+		_ = conn.Close()
+		return
+	}
+
+	select {
+	case l.sem <- struct{}{}:
+	default:
+		l.evtlog.Printf("call-home: max concurrent sessions reached, rejecting %s\n", source)
+		_ = conn.Close()
+		return
+	}
+	release := func() { <-l.sem }
+
+	t, fingerprint, err := l.factory(conn)
+	if err != nil {
+		l.evtlog.Printf("call-home: transport handshake with %s failed: %v\n", source, err)
+		_ = conn.Close()
+		release()
+		return
+	}
+
+	name, known := l.registry.Lookup(fingerprint)
+	if !known {
+		l.evtlog.Printf("call-home: unrecognised device fingerprint %q from %s\n", fingerprint, source)
+		name = fingerprint
+	}
+
+	sess, err := NewSession(t, l.evtlog, l.nclog)
+	if err != nil {
+		l.evtlog.Printf("call-home: session setup with %s (%s) failed: %v\n", name, source, err)
+		release()
+		return
+	}
+
+	l.nclog.Printf("call-home: session established with %s (%s)\n", name, source)
+	l.sessions <- &semBoundSession{Session: sess, release: release}
+}
+
+func (l *CallHomeListener) allowSource(source string) bool {
+	if l.perSource <= 0 {
+		return true
+	}
+
+	l.lastSeenMu.Lock()
+	defer l.lastSeenMu.Unlock()
+
+	now := time.Now()
+	if last, ok := l.lastSeen[source]; ok && now.Sub(last) < l.perSource {
+		return false
+	}
+	l.lastSeen[source] = now
+	return true
+}
+
+func sourceHost(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return fmt.Sprintf("%v", conn.RemoteAddr())
+	}
+	return host
+}
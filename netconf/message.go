@@ -1,14 +1,18 @@
 package netconf
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 
 	"github.com/satori/go.uuid"
 
 	"io"
 	"log"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/damianoneill/net/netconf/rfc6242"
 )
@@ -18,16 +22,41 @@ import (
 
 // Session represents a Netconf Session
 type Session interface {
-	// Execute executes an RPC request on the server and returns the reply.
-	Execute(req Request) (*RPCReply, error)
+	// Execute executes an RPC request on the server and returns the reply. If ctx is
+	// cancelled or its deadline expires before a reply arrives, Execute returns ctx.Err()
+	// and discards the reply if it arrives later.
+	Execute(ctx context.Context, req Request) (*RPCReply, error)
 
 	// ExecuteAsync submits an RPC request for execution on the server, arranging for the
-	// reply to be sent to the supplied channel.
-	ExecuteAsync(req Request, rchan chan *RPCReply) (err error)
-
-	// Subscribe issues an RPC request and returns the reply. If successful, notifications will
-	// be sent to the supplied channel.
-	Subscribe(req Request, nchan chan *Notification) (reply *RPCReply, err error)
+	// reply to be sent to the supplied channel. ctx is checked before the request is sent;
+	// once sent, the request is not cancelled: the reply is sent to rchan, or rchan is
+	// closed if the session is closed or reset by a reconnect before the reply arrives.
+	ExecuteAsync(ctx context.Context, req Request, rchan chan *RPCReply) (err error)
+
+	// Subscribe issues an RPC request establishing a notification subscription and, if
+	// successful, returns a Subscription that delivers matching notifications to nchan.
+	// Multiple subscriptions may be active concurrently on the same session; each is
+	// demultiplexed independently. Callers must eventually call Unsubscribe to release it.
+	Subscribe(req Request, nchan chan *Notification) (sub *Subscription, err error)
+
+	// RegisterNotificationHandler arranges for h to be invoked, on a dedicated
+	// notification-dispatch goroutine, for every subsequent <notification> whose
+	// event QName matches xmlName. Handlers run on a fixed pool of notifyWorkers
+	// goroutines, so h may be called concurrently with itself and not in arrival
+	// order. A slow handler does not stall the decoder until every worker is busy
+	// and notifyQueueSize invocations are already queued; the decoder then waits
+	// for a free queue slot.
+	RegisterNotificationHandler(xmlName xml.Name, h func(*Notification))
+
+	// UnregisterNotificationHandler removes the handler (if any) registered for
+	// xmlName. Subsequent matching notifications fall back to subscription-based
+	// delivery.
+	UnregisterNotificationHandler(xmlName xml.Name)
+
+	// SetObserver installs o to receive lifecycle callbacks for this session's
+	// RPCs, notifications and framing errors, replacing any observer set
+	// previously. Pass nil to go back to observing nothing.
+	SetObserver(o SessionObserver)
 
 	// Close closes the session and releases any associated resources.
 	Close()
@@ -43,8 +72,19 @@ type sesImpl struct {
 	pool []chan *RPCReply
 
 	hellochan chan *HelloMessage
-	responseq []chan *RPCReply
-	subchan   chan *Notification
+	responseq map[string]chan *RPCReply
+	pendErr   map[string]error // set for a msgID alongside closing its responseq channel, when the reply will never arrive
+
+	subs    map[string]*Subscription // keyed by Subscription.key()
+	subLock sync.Mutex
+
+	handlers    map[xml.Name]func(*Notification)
+	handlerLock sync.Mutex
+	notifyJobs  chan func()
+
+	observer SessionObserver
+	obsLock  sync.Mutex
+	rpcStart map[string]time.Time // msgID -> send time, for OnRPCEnd's duration
 
 	hello   *HelloMessage
 	reqLock sync.Mutex
@@ -57,6 +97,10 @@ var DefaultCapabilities = []string{
 	CapBase10,
 }
 
+// ErrSessionClosed is returned by Execute when the session ends (Close, or
+// an unrecoverable transport error) while the RPC is still in flight.
+var ErrSessionClosed = errors.New("netconf: session closed")
+
 var (
 	netconfNS       = "urn:ietf:params:xml:ns:netconf:base:1.0"
 	netconfNotifyNS = "urn:ietf:params:xml:ns:netconf:notification:1.0"
@@ -73,15 +117,36 @@ func NewSession(t Transport, evtlog *log.Logger, nclog *log.Logger) (Session, er
 	dec := newDecoder(t)
 	enc := newEncoder(t)
 
-	sess := &sesImpl{t: t, dec: dec, enc: enc, evtlog: evtlog, nclog: nclog, hellochan: make(chan *HelloMessage)}
+	sess := &sesImpl{t: t, dec: dec, enc: enc, evtlog: evtlog, nclog: nclog,
+		hellochan: make(chan *HelloMessage), responseq: make(map[string]chan *RPCReply),
+		pendErr:  make(map[string]error),
+		subs:     make(map[string]*Subscription),
+		handlers: make(map[xml.Name]func(*Notification)), notifyJobs: make(chan func(), notifyQueueSize),
+		observer: nopObserver{}, rpcStart: make(map[string]time.Time)}
+
+	sess.startNotificationWorkers()
 
 	go sess.handleInput()
 
-	sess.hello = <-sess.hellochan
+	if err := sess.negotiateHello(); err != nil {
+		return nil, err
+	}
+
+	return sess, nil
+}
+
+// negotiateHello performs the NETCONF <hello> exchange: it waits for the
+// peer's hello (delivered by handleInput on si.hellochan), replies with
+// DefaultCapabilities (or base:1.1 only, if the peer supports it), and
+// switches the transport to chunked framing when both ends negotiated
+// base:1.1. It is used both for the initial handshake and, by
+// ReconnectingTransport, to renegotiate after a reconnect.
+func (si *sesImpl) negotiateHello() error {
+	si.hello = <-si.hellochan
 
 	helloresp := &HelloMessage{Capabilities: DefaultCapabilities}
 	chunkedFraming := false
-	for _, capability := range sess.hello.Capabilities {
+	for _, capability := range si.hello.Capabilities {
 		if capability == CapBase11 {
 			helloresp.Capabilities = []string{CapBase11}
 			chunkedFraming = true
@@ -89,52 +154,123 @@ func NewSession(t Transport, evtlog *log.Logger, nclog *log.Logger) (Session, er
 		}
 	}
 
-	err := sess.enc.encode(helloresp)
-	if err != nil {
-		return nil, err
+	if err := si.enc.encode(helloresp); err != nil {
+		return err
 	}
 
 	if chunkedFraming {
-		rfc6242.SetChunkedFraming(sess.dec.ncDecoder, sess.enc.ncEncoder)
+		rfc6242.SetChunkedFraming(si.dec.ncDecoder, si.enc.ncEncoder)
 	}
 
-	return sess, nil
+	return nil
 }
 
-func (si *sesImpl) Execute(req Request) (*RPCReply, error) {
+func (si *sesImpl) Execute(ctx context.Context, req Request) (*RPCReply, error) {
 
 	rchan := si.allocChan()
-	defer si.relChan(rchan)
 
-	err := si.ExecuteAsync(req, rchan)
+	msgID, err := si.send(ctx, req, rchan)
 	if err != nil {
+		si.relChan(rchan)
 		return nil, err
 	}
-	reply := <-rchan
-	return reply, nil
+
+	select {
+	case reply := <-rchan:
+		if reply == nil {
+			// rchan was closed by failPending (session reset or closed while
+			// this RPC was in flight), not sent a reply: a closed channel
+			// cannot be un-closed, so it must not go back to the pool - a
+			// later allocChan recipient would see a spurious immediate nil
+			// reply, or panic trying to send on it.
+			return nil, si.takePendErr(msgID)
+		}
+		si.relChan(rchan)
+		return reply, nil
+	case <-ctx.Done():
+		// Abandon the request: drop its slot so a reply arriving after the
+		// caller has given up is discarded rather than mis-routed to a
+		// subsequent Execute that happens to reuse rchan. rchan is not
+		// returned to the pool either: handleInput may have already popped
+		// it (races with removeRespChan below) and be about to deliver a
+		// late reply - allocChan gives rchan a one-reply buffer precisely
+		// so that the goroutine making that late delivery cannot block, but
+		// the reply it carries must not be handed to a later, unrelated
+		// Execute call that happens to be given this same channel from the
+		// pool. Dropping it here lets it be garbage collected once any such
+		// late send lands.
+		si.removeRespChan(msgID)
+		si.reportRPCEnd(msgID, ctx.Err())
+		return nil, ctx.Err()
+	}
+}
+
+func (si *sesImpl) ExecuteAsync(ctx context.Context, req Request, rchan chan *RPCReply) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	_, err = si.send(ctx, req, rchan)
+	return err
 }
 
-func (si *sesImpl) ExecuteAsync(req Request, rchan chan *RPCReply) (err error) {
+// send encodes and transmits req, registering rchan against the generated
+// message-id so the reply (matched by that id) is routed back to it. ctx is
+// passed through to SessionObserver.OnRPCStart only, so a span created there
+// can be parented under the caller's trace; it is not otherwise consulted here
+// (cancellation is handled by Execute/ExecuteAsync's callers).
+func (si *sesImpl) send(ctx context.Context, req Request, rchan chan *RPCReply) (msgID string, err error) {
 	si.reqLock.Lock()
 	defer si.reqLock.Unlock()
-	msg := &RPCMessage{MessageID: uuid.NewV4().String(), Methods: []byte(string(req))}
+	msgID = uuid.NewV4().String()
+	msg := &RPCMessage{MessageID: msgID, Methods: []byte(string(req))}
 
-	si.pushRespChan(rchan)
+	si.pushRespChan(msgID, rchan)
 
-	return si.enc.encode(msg)
+	si.rchLock.Lock()
+	si.rpcStart[msgID] = time.Now()
+	si.rchLock.Unlock()
+	si.getObserver().OnRPCStart(ctx, msgID, requestMethod(req))
+
+	if err = si.enc.encode(msg); err != nil {
+		si.removeRespChan(msgID)
+		si.reportRPCEnd(msgID, err)
+		return "", err
+	}
+	return msgID, nil
 }
 
-func (si *sesImpl) Subscribe(req Request, nchan chan *Notification) (reply *RPCReply, err error) {
-	rchan := si.allocChan()
-	defer si.relChan(rchan)
+// reportRPCEnd fires OnRPCEnd for msgID exactly once, using whichever code
+// path (handleInput delivering a reply, Execute giving up on ctx, or
+// failPending discarding it wholesale) first reaches a terminal outcome for
+// it; later callers for the same msgID find no start time left and do nothing.
+func (si *sesImpl) reportRPCEnd(msgID string, err error) {
+	si.rchLock.Lock()
+	start, ok := si.rpcStart[msgID]
+	if ok {
+		delete(si.rpcStart, msgID)
+	}
+	si.rchLock.Unlock()
 
-	err = si.ExecuteAsync(req, rchan)
-	if err != nil {
+	if !ok {
 		return
 	}
-	si.subchan = nchan
-	reply = <-rchan
-	return
+	si.getObserver().OnRPCEnd(msgID, err, time.Since(start))
+}
+
+// SetObserver implements Session.
+func (si *sesImpl) SetObserver(o SessionObserver) {
+	if o == nil {
+		o = nopObserver{}
+	}
+	si.obsLock.Lock()
+	si.observer = o
+	si.obsLock.Unlock()
+}
+
+func (si *sesImpl) getObserver() SessionObserver {
+	si.obsLock.Lock()
+	defer si.obsLock.Unlock()
+	return si.observer
 }
 
 func (si *sesImpl) Close() {
@@ -153,6 +289,7 @@ func (si *sesImpl) handleInput() {
 			if err != io.EOF {
 				si.evtlog.Printf("Token() error: %v\n", err)
 			}
+			si.getObserver().OnFrameError(err)
 			break
 		}
 		switch token := token.(type) {
@@ -164,27 +301,39 @@ func (si *sesImpl) handleInput() {
 					si.evtlog.Printf("DecodeElement() error: %v\n", err)
 					return
 				}
+				si.getObserver().OnHello(&hello)
 				si.hellochan <- &hello
 			case nameRPCReply: // <rpc-reply>
+				msgID := attrValue(token, "message-id")
 				reply := RPCReply{}
 				if err := si.dec.DecodeElement(&reply, &token); err != nil {
 					si.evtlog.Printf("DecodeElement() error: %v\n", err)
 					return
 				}
 
-				respch := si.popRespChan()
+				respch := si.popRespChan(msgID)
+				if respch == nil {
+					si.evtlog.Printf("rpc-reply with no waiter for message-id %q - dropped\n", msgID)
+					si.reportRPCEnd(msgID, nil)
+					continue
+				}
+				si.reportRPCEnd(msgID, nil)
 				go func(ch chan *RPCReply, r *RPCReply) {
 					ch <- r
 				}(respch, &reply)
 
 			case notification: // <notification>
 
+				subID := attrValue(token, "subscription-id")
 				result := &NotificationMessage{}
 				_ = si.dec.DecodeElement(result, &token)
 				n := fmt.Sprintf(`<%s xmlns="%s">%s</%s>`,
 					result.Event.XMLName.Local, result.Event.XMLName.Space, result.Event.Event, result.Event.XMLName.Local)
-				if si.subchan != nil {
-					si.subchan <- &Notification{XMLName: result.Event.XMLName, EventTime: result.EventTime, Event: n}
+				notif := &Notification{XMLName: result.Event.XMLName, EventTime: result.EventTime, Event: n}
+				si.getObserver().OnNotification(notif.XMLName)
+
+				if !si.dispatchToHandler(notif) {
+					si.routeNotification(subID, notif)
 				}
 
 			default:
@@ -197,19 +346,23 @@ func (si *sesImpl) handleInput() {
 
 func (si *sesImpl) closeChannels() {
 	close(si.hellochan)
-	if si.subchan != nil {
-		close(si.subchan)
-	}
+	si.closeAllSubscriptions()
 	si.closeAllResponseChannels()
+	close(si.notifyJobs)
 }
 
+// allocChan returns a channel for a single RPC reply, buffered to hold
+// exactly one value so that a late reply - handed by handleInput to a
+// delivery goroutine after its caller has already given up waiting on it
+// (see Execute's ctx.Done case) - can always be sent without blocking that
+// goroutine forever.
 func (si *sesImpl) allocChan() (ch chan *RPCReply) {
 	si.pchLock.Lock()
 	defer si.pchLock.Unlock()
 
 	l := len(si.pool)
 	if l == 0 {
-		return make(chan *RPCReply)
+		return make(chan *RPCReply, 1)
 	}
 
 	si.pool, ch = si.pool[:l-1], si.pool[l-1]
@@ -222,28 +375,88 @@ func (si *sesImpl) relChan(ch chan *RPCReply) {
 	si.pool = append(si.pool, ch)
 }
 
-func (si *sesImpl) pushRespChan(ch chan *RPCReply) {
+func (si *sesImpl) pushRespChan(msgID string, ch chan *RPCReply) {
 	si.rchLock.Lock()
 	defer si.rchLock.Unlock()
-	si.responseq = append(si.responseq, ch)
-
+	si.responseq[msgID] = ch
 }
 
-func (si *sesImpl) popRespChan() (ch chan *RPCReply) {
+// popRespChan removes and returns the channel waiting for msgID, or nil if
+// there is no such waiter (already delivered, timed out, or unsolicited reply).
+func (si *sesImpl) popRespChan(msgID string) (ch chan *RPCReply) {
 	si.rchLock.Lock()
 	defer si.rchLock.Unlock()
-	if len(si.responseq) > 0 {
-		si.responseq, ch = si.responseq[1:], si.responseq[0]
-	}
+	ch = si.responseq[msgID]
+	delete(si.responseq, msgID)
 	return
 }
 
+// removeRespChan discards a pending waiter without returning it, used when a
+// caller abandons a request (e.g. its context is cancelled) before a reply arrives.
+func (si *sesImpl) removeRespChan(msgID string) {
+	si.rchLock.Lock()
+	defer si.rchLock.Unlock()
+	delete(si.responseq, msgID)
+}
+
 func (si *sesImpl) closeAllResponseChannels() {
+	si.failPending(ErrSessionClosed)
+}
+
+// failPending fails every currently pending request with err: each waiter's
+// channel is closed (so a blocked receive wakes with a nil reply) and err is
+// recorded for takePendErr to retrieve.
+func (si *sesImpl) failPending(err error) {
+	si.rchLock.Lock()
+	failed := make([]string, 0, len(si.responseq))
+	for msgID, ch := range si.responseq {
+		si.pendErr[msgID] = err
+		close(ch)
+		delete(si.responseq, msgID)
+		failed = append(failed, msgID)
+	}
+	si.rchLock.Unlock()
+
+	for _, msgID := range failed {
+		si.reportRPCEnd(msgID, err)
+	}
+}
+
+// takePendErr returns and clears the error recorded for msgID by failPending,
+// defaulting to ErrSessionClosed if none was recorded (channel closed without
+// going through failPending, which should not normally happen).
+func (si *sesImpl) takePendErr(msgID string) error {
+	si.rchLock.Lock()
+	defer si.rchLock.Unlock()
+	if err, ok := si.pendErr[msgID]; ok {
+		delete(si.pendErr, msgID)
+		return err
+	}
+	return ErrSessionClosed
+}
+
+// attrValue returns the value of the named attribute on el, or "" if absent.
+func attrValue(el xml.StartElement, name string) string {
+	for _, a := range el.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// requestMethod returns the local name of req's top-level element (e.g.
+// "get-config", "edit-config"), for use as the "method" label on observer
+// callbacks. It falls back to "unknown" if req isn't well-formed XML.
+func requestMethod(req Request) string {
+	dec := xml.NewDecoder(strings.NewReader(string(req)))
 	for {
-		if ch := si.popRespChan(); ch != nil {
-			close(ch)
-		} else {
-			return
+		tok, err := dec.Token()
+		if err != nil {
+			return "unknown"
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name.Local
 		}
 	}
 }
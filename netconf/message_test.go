@@ -0,0 +1,67 @@
+package netconf
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCancelledExecuteChannelIsNotReusedForLateReply is a regression test for
+// the race fixed alongside it: handleInput's decode loop may have already
+// called popRespChan(msgID) - removing the waiter and about to deliver a
+// reply on it from its own goroutine - at the exact moment Execute's caller
+// gives up via ctx.Done(). Returning that channel to the pool in that case
+// let the late reply either block its sender forever (with an unbuffered
+// channel) or, worse, be delivered to a later, unrelated Execute call that
+// happened to be handed the same channel out of the pool.
+//
+// This exercises allocChan's one-reply buffer directly and replays by hand
+// the calls Execute's ctx.Done branch makes (removeRespChan, and no relChan),
+// since send/Execute's encode/decode round trip isn't exercisable without a
+// real Transport.
+func TestCancelledExecuteChannelIsNotReusedForLateReply(t *testing.T) {
+	si := &sesImpl{
+		responseq: make(map[string]chan *RPCReply),
+		pendErr:   make(map[string]error),
+		rpcStart:  make(map[string]time.Time),
+		observer:  nopObserver{},
+	}
+
+	const msgID = "msg-1"
+	rchan := si.allocChan()
+	si.pushRespChan(msgID, rchan)
+
+	// handleInput's decode loop races ahead: it pops the waiter and spawns
+	// its late-delivery goroutine exactly as it does in production.
+	popped := si.popRespChan(msgID)
+	if popped != rchan {
+		t.Fatalf("popRespChan returned a different channel than was registered for %s", msgID)
+	}
+	reply := &RPCReply{}
+	go func(ch chan *RPCReply, r *RPCReply) { ch <- r }(popped, reply)
+
+	// Execute's ctx.Done branch: its own removal is a no-op (handleInput's
+	// popRespChan above already won the race), and - this is the fix under
+	// test - it must not relChan(rchan) back into the pool.
+	si.removeRespChan(msgID)
+
+	// The late send must complete without blocking its goroutine forever;
+	// allocChan's one-reply buffer is what makes that true.
+	select {
+	case r := <-rchan:
+		if r != reply {
+			t.Fatalf("got %v, want the late reply %v", r, reply)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("late reply was never delivered - allocChan's reply buffer regressed")
+	}
+
+	// Execute's ctx.Done branch (the fix under test) never calls relChan in
+	// this situation, so the raced channel must never reappear from the
+	// pool for a later, unrelated caller.
+	if len(si.pool) != 0 {
+		t.Fatalf("pool = %d entries, want 0: a raced rchan must never be returned to the pool", len(si.pool))
+	}
+	if fresh := si.allocChan(); fresh == rchan {
+		t.Fatalf("allocChan returned the raced channel from %s - it must not be pooled", msgID)
+	}
+}
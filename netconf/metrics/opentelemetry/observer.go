@@ -0,0 +1,78 @@
+// Package opentelemetry adapts a netconf.Session's SessionObserver callbacks
+// to OpenTelemetry tracing, creating one span per RPC.
+package opentelemetry
+
+import (
+	"context"
+	"encoding/xml"
+	"sync"
+	"time"
+
+	"github.com/damianoneill/net/netconf"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Observer implements netconf.SessionObserver, starting a span named after
+// the RPC method on OnRPCStart and ending it on the matching OnRPCEnd, with
+// the error (if any) recorded on the span. The span is a child of the
+// context passed to Execute/ExecuteAsync, so it nests correctly under
+// whatever trace the caller is already part of.
+type Observer struct {
+	tracer trace.Tracer
+
+	mu    sync.Mutex
+	spans map[string]trace.Span // msgID -> in-flight span, started at OnRPCStart
+}
+
+// NewObserver creates an Observer using tracer to start spans.
+func NewObserver(tracer trace.Tracer) *Observer {
+	return &Observer{tracer: tracer, spans: make(map[string]trace.Span)}
+}
+
+// OnRPCStart implements netconf.SessionObserver.
+func (o *Observer) OnRPCStart(ctx context.Context, msgID, method string) {
+	_, span := o.tracer.Start(ctx, method,
+		trace.WithAttributes(attribute.String("netconf.message_id", msgID)))
+
+	o.mu.Lock()
+	o.spans[msgID] = span
+	o.mu.Unlock()
+}
+
+// OnRPCEnd implements netconf.SessionObserver.
+func (o *Observer) OnRPCEnd(msgID string, err error, dur time.Duration) {
+	o.mu.Lock()
+	span, ok := o.spans[msgID]
+	delete(o.spans, msgID)
+	o.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+	span.End()
+}
+
+// OnNotification implements netconf.SessionObserver.
+func (o *Observer) OnNotification(name xml.Name) {
+	_, span := o.tracer.Start(context.Background(), "notification",
+		trace.WithAttributes(attribute.String("netconf.event", name.Local)))
+	span.End()
+}
+
+// OnHello implements netconf.SessionObserver.
+func (o *Observer) OnHello(hello *netconf.HelloMessage) {}
+
+// OnFrameError implements netconf.SessionObserver.
+func (o *Observer) OnFrameError(err error) {
+	_, span := o.tracer.Start(context.Background(), "frame-error")
+	span.SetStatus(codes.Error, err.Error())
+	span.End()
+}
@@ -0,0 +1,120 @@
+// Package prometheus adapts a netconf.Session's SessionObserver callbacks to
+// Prometheus counters and histograms, one set of series per Session.
+package prometheus
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/damianoneill/net/netconf"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Observer implements netconf.SessionObserver, recording:
+//   - rpc_total{method,result}           count of completed RPCs
+//   - rpc_duration_seconds{method,result} histogram of RPC latency
+//   - notifications_total{name}          count of received notifications
+//   - frame_errors_total                 count of OnFrameError callbacks
+//
+// result is one of a small fixed set of classes - "ok", "timeout", "reset",
+// "closed", or "error" - rather than the error's own string, since an
+// arbitrary I/O error string (e.g. "dial tcp 10.0.0.5:830: i/o timeout")
+// used directly as a label value would give every distinct failure its own
+// time series.
+type Observer struct {
+	rpcTotal      *prometheus.CounterVec
+	rpcDuration   *prometheus.HistogramVec
+	notifications *prometheus.CounterVec
+	frameErrors   prometheus.Counter
+
+	mu      sync.Mutex
+	methods map[string]string // msgID -> method, recorded at OnRPCStart for OnRPCEnd to label with
+}
+
+// NewObserver creates an Observer and registers its collectors with reg.
+func NewObserver(reg prometheus.Registerer) *Observer {
+	o := &Observer{
+		rpcTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "netconf",
+			Name:      "rpc_total",
+			Help:      "Total NETCONF RPCs completed, by method and result.",
+		}, []string{"method", "result"}),
+		rpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "netconf",
+			Name:      "rpc_duration_seconds",
+			Help:      "NETCONF RPC latency in seconds, by method and result.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "result"}),
+		notifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "netconf",
+			Name:      "notifications_total",
+			Help:      "Total NETCONF notifications received, by event name.",
+		}, []string{"name"}),
+		frameErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "netconf",
+			Name:      "frame_errors_total",
+			Help:      "Total errors reading or decoding a NETCONF PDU.",
+		}),
+		methods: make(map[string]string),
+	}
+
+	reg.MustRegister(o.rpcTotal, o.rpcDuration, o.notifications, o.frameErrors)
+	return o
+}
+
+// OnRPCStart implements netconf.SessionObserver.
+func (o *Observer) OnRPCStart(ctx context.Context, msgID, method string) {
+	o.mu.Lock()
+	o.methods[msgID] = method
+	o.mu.Unlock()
+}
+
+// OnRPCEnd implements netconf.SessionObserver.
+func (o *Observer) OnRPCEnd(msgID string, err error, dur time.Duration) {
+	o.mu.Lock()
+	method, ok := o.methods[msgID]
+	delete(o.methods, msgID)
+	o.mu.Unlock()
+
+	if !ok {
+		method = "unknown"
+	}
+	result := resultClass(err)
+
+	o.rpcTotal.WithLabelValues(method, result).Inc()
+	o.rpcDuration.WithLabelValues(method, result).Observe(dur.Seconds())
+}
+
+// resultClass maps err to one of a small fixed set of label values, so that
+// arbitrary transport/encode error strings never become Prometheus label
+// values themselves.
+func resultClass(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, netconf.ErrSessionReset):
+		return "reset"
+	case errors.Is(err, netconf.ErrSessionClosed), errors.Is(err, context.Canceled):
+		return "closed"
+	default:
+		return "error"
+	}
+}
+
+// OnNotification implements netconf.SessionObserver.
+func (o *Observer) OnNotification(name xml.Name) {
+	o.notifications.WithLabelValues(name.Local).Inc()
+}
+
+// OnHello implements netconf.SessionObserver.
+func (o *Observer) OnHello(hello *netconf.HelloMessage) {}
+
+// OnFrameError implements netconf.SessionObserver.
+func (o *Observer) OnFrameError(err error) {
+	o.frameErrors.Inc()
+}
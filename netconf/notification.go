@@ -0,0 +1,55 @@
+package netconf
+
+import "encoding/xml"
+
+// notifyWorkers is the size of the fixed goroutine pool that invokes
+// registered notification handlers, so a single slow handler cannot stall
+// the session's decode loop.
+const notifyWorkers = 8
+
+// notifyQueueSize bounds how many dispatched-but-not-yet-run handler
+// invocations may be queued before a burst of notifications starts to
+// apply backpressure to the decoder.
+const notifyQueueSize = 64
+
+// RegisterNotificationHandler implements Session.
+func (si *sesImpl) RegisterNotificationHandler(xmlName xml.Name, h func(*Notification)) {
+	si.handlerLock.Lock()
+	defer si.handlerLock.Unlock()
+	si.handlers[xmlName] = h
+}
+
+// UnregisterNotificationHandler implements Session.
+func (si *sesImpl) UnregisterNotificationHandler(xmlName xml.Name) {
+	si.handlerLock.Lock()
+	defer si.handlerLock.Unlock()
+	delete(si.handlers, xmlName)
+}
+
+// dispatchToHandler queues n for the handler registered against n.XMLName, if
+// any, returning true if such a handler exists. It blocks only while
+// notifyQueueSize invocations are already queued, until a worker takes one.
+func (si *sesImpl) dispatchToHandler(n *Notification) bool {
+	si.handlerLock.Lock()
+	h, ok := si.handlers[n.XMLName]
+	si.handlerLock.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	si.notifyJobs <- func() { h(n) }
+	return true
+}
+
+// startNotificationWorkers launches the fixed pool of goroutines that drain
+// si.notifyJobs for the lifetime of the session.
+func (si *sesImpl) startNotificationWorkers() {
+	for i := 0; i < notifyWorkers; i++ {
+		go func() {
+			for job := range si.notifyJobs {
+				job()
+			}
+		}()
+	}
+}
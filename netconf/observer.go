@@ -0,0 +1,62 @@
+package netconf
+
+import (
+	"context"
+	"encoding/xml"
+	"time"
+)
+
+// SessionObserver receives lifecycle callbacks for a Session's RPCs,
+// notifications, and transport/framing errors, giving operators the kind of
+// cross-cutting observability grpc's stats.Handler provides without
+// requiring a fork of this library. Implementations must be safe for
+// concurrent use: callbacks fire from whichever goroutine reaches the
+// relevant event (the session's decode loop for OnHello/OnNotification/
+// OnFrameError, the caller's own goroutine for OnRPCStart, and for OnRPCEnd
+// the decode loop on a reply or session close, the caller's goroutine on
+// ctx expiry or a send failure, or the reconnect goroutine on a reset).
+// They should not block; a slow observer will delay the decode loop for
+// OnHello/OnNotification/OnFrameError and for most OnRPCEnd calls, and
+// delay the caller for OnRPCStart.
+type SessionObserver interface {
+	// OnRPCStart fires when an RPC identified by msgID is sent, naming its
+	// top-level operation (e.g. "get-config") as method. ctx is the context
+	// passed to Execute/ExecuteAsync, so an observer that creates spans can
+	// parent them under the caller's trace.
+	OnRPCStart(ctx context.Context, msgID, method string)
+
+	// OnRPCEnd fires once for every OnRPCStart, when that RPC's outcome is
+	// known: err is nil on a normal reply, the write error if sending the
+	// RPC failed, or ctx.Err()/ErrSessionReset/ErrSessionClosed if the RPC
+	// never completed normally. dur is measured from the matching OnRPCStart.
+	OnRPCEnd(msgID string, err error, dur time.Duration)
+
+	// OnNotification fires for every <notification> received, naming its
+	// event QName, regardless of whether a handler or subscription is
+	// registered for it.
+	OnNotification(name xml.Name)
+
+	// OnHello fires when the peer's <hello> is received, before this
+	// session's own <hello> reply is sent.
+	OnHello(hello *HelloMessage)
+
+	// OnFrameError fires when the session's decode loop fails to read the
+	// next XML token, including a normal io.EOF on session close. A <hello>
+	// or <rpc-reply> whose body fails to decode ends the decode loop without
+	// this callback. This
+	// covers rfc6242 chunked-framing failures too: once negotiateHello
+	// switches the transport to chunked framing, a malformed chunk surfaces
+	// as a Token/Read error on the same decode loop, not through a separate
+	// path.
+	OnFrameError(err error)
+}
+
+// nopObserver is the default SessionObserver: it does nothing. sesImpl always
+// holds a non-nil observer so callback sites never need a nil check.
+type nopObserver struct{}
+
+func (nopObserver) OnRPCStart(context.Context, string, string) {}
+func (nopObserver) OnRPCEnd(string, error, time.Duration)      {}
+func (nopObserver) OnNotification(xml.Name)                    {}
+func (nopObserver) OnHello(*HelloMessage)                      {}
+func (nopObserver) OnFrameError(error)                         {}
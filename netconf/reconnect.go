@@ -0,0 +1,234 @@
+package netconf
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrSessionReset is returned by Execute for any RPC that was in flight when
+// the underlying transport was lost and successfully redialed. The session
+// itself remains usable; the caller should retry the RPC if it is idempotent.
+var ErrSessionReset = errors.New("netconf: session reset by reconnect")
+
+// TransportDialer establishes a fresh Transport, e.g. by opening a new TCP/SSH
+// connection to the device. It is called once up front and again on every
+// reconnect attempt.
+type TransportDialer func(ctx context.Context) (Transport, error)
+
+// BackoffConfig controls the delay between reconnect attempts, following the
+// standard grpc-style formula: delay = min(MaxDelay, BaseDelay*Multiplier^n),
+// randomized by ±Jitter (as a fraction of delay, uniformly distributed), and
+// reset to attempt zero after a successful redial.
+type BackoffConfig struct {
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+// DefaultBackoffConfig is a reasonable starting point for reconnecting to a
+// device over a local network.
+var DefaultBackoffConfig = BackoffConfig{
+	BaseDelay:  time.Second,
+	MaxDelay:   time.Minute,
+	Multiplier: 1.6,
+	Jitter:     0.2,
+}
+
+func (b BackoffConfig) delay(attempt int) time.Duration {
+	d := float64(b.BaseDelay) * math.Pow(b.Multiplier, float64(attempt))
+	if max := float64(b.MaxDelay); d > max {
+		d = max
+	}
+	d += d * b.Jitter * (2*rand.Float64() - 1)
+	if d < 0 {
+		d = 0
+	}
+	return time.Duration(d)
+}
+
+// ReconnectingTransport wraps a Transport obtained from a TransportDialer and
+// transparently redials, with exponential backoff, whenever a Read or Write
+// fails with io.EOF or another transport-level error. It implements
+// Transport, so it can be passed to NewSession like any other transport.
+//
+// Reconnection is invisible at the byte-stream level only; restoring NETCONF
+// session state (the <hello> exchange, active subscriptions, in-flight RPCs)
+// requires cooperation from the owning Session, which is why
+// NewResilientSession, not ReconnectingTransport alone, is the entry point
+// intended for callers.
+type ReconnectingTransport struct {
+	dialer  TransportDialer
+	backoff BackoffConfig
+	evtlog  *log.Logger
+
+	// onReconnect is invoked, with the newly-dialed Transport, on its own
+	// goroutine after each successful redial, concurrently with Read/Write
+	// resuming on it. It must not be called synchronously from reconnect:
+	// reconnect runs on whatever goroutine's Read/Write triggered it, which
+	// for NewResilientSession is the session's own decode-loop goroutine, and
+	// onReconnect's hello renegotiation needs that same decode loop free to
+	// read the new peer hello and hand it off.
+	onReconnect func(Transport) error
+
+	mu      sync.Mutex
+	current Transport
+	closed  bool
+}
+
+// NewReconnectingTransport dials an initial Transport via dialer and returns
+// a ReconnectingTransport wrapping it. backoff governs subsequent reconnect
+// attempts.
+func NewReconnectingTransport(ctx context.Context, dialer TransportDialer, backoff BackoffConfig, evtlog *log.Logger) (*ReconnectingTransport, error) {
+	t, err := dialer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &ReconnectingTransport{dialer: dialer, backoff: backoff, evtlog: evtlog, current: t}, nil
+}
+
+func (rt *ReconnectingTransport) Read(p []byte) (int, error) {
+	for {
+		rt.mu.Lock()
+		t := rt.current
+		rt.mu.Unlock()
+
+		n, err := t.Read(p)
+		if err == nil || !rt.shouldReconnect(err) {
+			return n, err
+		}
+		if rerr := rt.reconnect(); rerr != nil {
+			return n, rerr
+		}
+	}
+}
+
+func (rt *ReconnectingTransport) Write(p []byte) (int, error) {
+	for {
+		rt.mu.Lock()
+		t := rt.current
+		rt.mu.Unlock()
+
+		n, err := t.Write(p)
+		if err == nil || !rt.shouldReconnect(err) {
+			return n, err
+		}
+		if rerr := rt.reconnect(); rerr != nil {
+			return n, rerr
+		}
+	}
+}
+
+// Close permanently shuts down the transport; it will not reconnect again.
+func (rt *ReconnectingTransport) Close() error {
+	rt.mu.Lock()
+	rt.closed = true
+	t := rt.current
+	rt.mu.Unlock()
+	return t.Close()
+}
+
+func (rt *ReconnectingTransport) shouldReconnect(err error) bool {
+	if err == io.EOF {
+		return true
+	}
+	// Treat any other non-nil error from the underlying stream as a transport
+	// failure too; NETCONF framing errors surface separately via the decoder
+	// and are not routed through here.
+	return err != nil
+}
+
+// reconnect redials with exponential backoff until dialer succeeds or the
+// transport has been closed. The freshly-dialed Transport is made current
+// immediately, then onReconnect (if set) is started on its own goroutine
+// and reconnect returns without waiting for it.
+//
+// onReconnect cannot be called synchronously here: reconnect runs on the
+// same goroutine as the Read/Write call that detected the failure, which
+// for NewResilientSession is the session's decode-loop goroutine, and
+// onReconnect's hello renegotiation blocks waiting for that same decode
+// loop to deliver the peer's new hello. Calling it inline would deadlock
+// the loop against itself. Running it async means a failure there can no
+// longer trigger a redial of a different Transport - it is logged instead,
+// since the caller's Read/Write has already moved on to t by the time it
+// would fail.
+func (rt *ReconnectingTransport) reconnect() error {
+	for attempt := 0; ; attempt++ {
+		rt.mu.Lock()
+		closed := rt.closed
+		rt.mu.Unlock()
+		if closed {
+			return errors.New("netconf: transport closed")
+		}
+
+		if attempt > 0 {
+			time.Sleep(rt.backoff.delay(attempt - 1))
+		}
+
+		t, err := rt.dialer(context.Background())
+		if err != nil {
+			if rt.evtlog != nil {
+				rt.evtlog.Printf("reconnect attempt %d failed: %v\n", attempt, err)
+			}
+			continue
+		}
+
+		rt.mu.Lock()
+		rt.current = t
+		rt.mu.Unlock()
+
+		if rt.onReconnect != nil {
+			go func() {
+				if err := rt.onReconnect(t); err != nil && rt.evtlog != nil {
+					rt.evtlog.Printf("reconnect hook failed: %v\n", err)
+				}
+			}()
+		}
+
+		return nil
+	}
+}
+
+// NewResilientSession builds a Session on top of a ReconnectingTransport, so
+// the session survives transport loss (e.g. a device reboot) for as long as
+// TransportDialer keeps succeeding. On every successful reconnect it
+// renegotiates <hello>, reissues all Subscriptions active at the time of the
+// loss, and fails every RPC that was in flight with ErrSessionReset so the
+// caller can retry it.
+func NewResilientSession(ctx context.Context, dialer TransportDialer, backoff BackoffConfig, evtlog *log.Logger, nclog *log.Logger) (Session, error) {
+	rt, err := NewReconnectingTransport(ctx, dialer, backoff, evtlog)
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := NewSession(rt, evtlog, nclog)
+	if err != nil {
+		return nil, err
+	}
+
+	si := sess.(*sesImpl)
+	rt.onReconnect = func(t Transport) error {
+		// si.dec/si.enc already wrap rt, not t directly, so they keep working
+		// transparently once rt.current is swapped to t by reconnect() - do
+		// not rebuild them or start a second handleInput here. reconnect()
+		// runs this hook on a separate goroutine (see reconnect's comment),
+		// so si.handleInput's own goroutine is free to resume reading
+		// through rt and deliver the peer's new hello on si.hellochan, which
+		// negotiateHello below is waiting to receive.
+		if err := si.negotiateHello(); err != nil {
+			return err
+		}
+
+		si.failPending(ErrSessionReset)
+		si.resubscribeAll()
+		return nil
+	}
+
+	return sess, nil
+}
@@ -0,0 +1,145 @@
+package netconf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDevicePeer is the remote-device end of a net.Pipe, speaking just enough
+// of base:1.0 framed NETCONF (a "]]>]]>" terminator after every PDU) to drive
+// negotiateHello and Execute from the other end, without depending on this
+// package's own (external) decoder/encoder.
+type fakeDevicePeer struct {
+	conn net.Conn
+	buf  bytes.Buffer
+}
+
+func newFakeDevicePeer(conn net.Conn) *fakeDevicePeer {
+	return &fakeDevicePeer{conn: conn}
+}
+
+func (p *fakeDevicePeer) readFrame() (string, error) {
+	const term = "]]>]]>"
+	tmp := make([]byte, 4096)
+	for {
+		if idx := bytes.Index(p.buf.Bytes(), []byte(term)); idx >= 0 {
+			frame := string(p.buf.Bytes()[:idx])
+			p.buf.Next(idx + len(term))
+			return frame, nil
+		}
+		n, err := p.conn.Read(tmp)
+		if n > 0 {
+			p.buf.Write(tmp[:n])
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+func (p *fakeDevicePeer) writeFrame(xml string) error {
+	_, err := p.conn.Write([]byte(xml + "]]>]]>"))
+	return err
+}
+
+const fakeHelloXML = `<hello xmlns="urn:ietf:params:xml:ns:netconf:base:1.0"><capabilities><capability>urn:ietf:params:netconf:base:1.0</capability></capabilities></hello>`
+
+var messageIDPattern = regexp.MustCompile(`message-id="([^"]*)"`)
+
+// runFakeDevice performs the hello exchange on conn and then replies to every
+// RPC it receives with a trivial <ok/> reply, until conn is closed - by the
+// test (via kill) or by the peer. It runs until readFrame fails.
+func runFakeDevice(conn net.Conn, kill <-chan struct{}) {
+	if kill != nil {
+		go func() {
+			<-kill
+			_ = conn.Close()
+		}()
+	}
+
+	// The device sends its hello first: negotiateHello waits for the peer's
+	// hello before sending its own.
+	peer := newFakeDevicePeer(conn)
+	if err := peer.writeFrame(fakeHelloXML); err != nil {
+		return
+	}
+	if _, err := peer.readFrame(); err != nil {
+		return
+	}
+
+	for {
+		frame, err := peer.readFrame()
+		if err != nil {
+			return
+		}
+		m := messageIDPattern.FindStringSubmatch(frame)
+		if m == nil {
+			continue
+		}
+		reply := fmt.Sprintf(`<rpc-reply xmlns="urn:ietf:params:xml:ns:netconf:base:1.0" message-id="%s"><ok/></rpc-reply>`, m[1])
+		if err := peer.writeFrame(reply); err != nil {
+			return
+		}
+	}
+}
+
+// TestNewResilientSessionReconnectDoesNotDeadlock is a regression test for
+// the deadlock fixed alongside this test: onReconnect used to call
+// negotiateHello synchronously from inside reconnect(), which runs on the
+// session's own decode-loop goroutine - the same goroutine negotiateHello
+// was waiting on to deliver the peer's new hello over si.hellochan. Every
+// reconnect hung the session forever. If this test times out, the deadlock
+// has regressed.
+func TestNewResilientSessionReconnectDoesNotDeadlock(t *testing.T) {
+	var mu sync.Mutex
+	var kill chan struct{}
+
+	dial := func(ctx context.Context) (Transport, error) {
+		client, server := net.Pipe()
+		k := make(chan struct{})
+		mu.Lock()
+		kill = k
+		mu.Unlock()
+		go runFakeDevice(server, k)
+		return client, nil
+	}
+
+	discard := log.New(io.Discard, "", 0)
+	backoff := BackoffConfig{BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, Multiplier: 1, Jitter: 0}
+
+	sess, err := NewResilientSession(context.Background(), dial, backoff, discard, discard)
+	if err != nil {
+		t.Fatalf("NewResilientSession: %v", err)
+	}
+	defer sess.Close()
+
+	mu.Lock()
+	k := kill
+	mu.Unlock()
+	close(k) // sever the current transport, forcing a reconnect + hello renegotiation
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := sess.Execute(ctx, Request(`<get/>`))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Execute after reconnect failed: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Execute after reconnect did not return - session deadlocked renegotiating hello")
+	}
+}
@@ -0,0 +1,187 @@
+package netconf
+
+import (
+	"context"
+	"fmt"
+)
+
+// Subscription represents an active notification subscription on a Session.
+// Notifications matching it are delivered to Notifications until the
+// subscription is closed by the server, the session ends, or Unsubscribe
+// is called.
+type Subscription struct {
+	// id is the dynamic subscription identifier assigned by the server in
+	// an RFC 8639 establish-subscription reply. It is "" for a classic
+	// RFC 5277 stream subscription, which NETCONF does not assign an id to.
+	id string
+
+	// req is the create-subscription/establish-subscription RPC that
+	// created this subscription, retained so Unsubscribe knows which kind
+	// of terminating RPC to issue.
+	req Request
+
+	// Notifications delivers notifications matched to this subscription.
+	// It is closed when the subscription ends. Delivery is non-blocking: a
+	// notification arriving while the consumer isn't receiving is dropped
+	// and logged rather than stalling the session's decode loop.
+	Notifications chan *Notification
+
+	// Reply is the create-subscription/establish-subscription RPC reply
+	// that established this subscription (or last re-established it, after
+	// a reconnect). Callers should inspect it for an <rpc-error> before
+	// trusting the subscription is actually active on the server.
+	Reply *RPCReply
+
+	si *sesImpl
+}
+
+// Subscribe issues req (a <create-subscription> or <establish-subscription> RPC)
+// and, on success, registers a Subscription that demultiplexes subsequent
+// <notification> messages to nchan. Several subscriptions may be active on the
+// same session at once.
+//
+// RFC 8639 dynamic subscriptions are demultiplexed precisely, by the
+// subscription-id carried on each notification. RFC 5277 does not carry any
+// such correlator in-band, so classic subscriptions are best-effort: a
+// notification with no subscription-id is fanned out to every classic
+// subscription currently registered on the session.
+func (si *sesImpl) Subscribe(req Request, nchan chan *Notification) (*Subscription, error) {
+	sub := &Subscription{req: req, Notifications: nchan, si: si}
+	if err := si.issueSubscribe(sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// issueSubscribe sends sub.req and, on success, (re)registers sub in si.subs.
+// It is shared by Subscribe (for a fresh Subscription) and resubscribeAll
+// (which reuses the caller's existing Subscription, so its identity and
+// Notifications channel survive a reconnect).
+//
+// Execute's own handling of a closed responseq channel (session reset or
+// closed while the reply was in flight) is reused here rather than reading
+// rchan directly, so that case surfaces as an error instead of silently
+// registering a subscription no reply ever confirmed.
+func (si *sesImpl) issueSubscribe(sub *Subscription) error {
+	reply, err := si.Execute(context.Background(), sub.req)
+	if err != nil {
+		return err
+	}
+	sub.Reply = reply
+
+	si.subLock.Lock()
+	si.subs[sub.key()] = sub
+	si.subLock.Unlock()
+
+	return nil
+}
+
+// key returns the map key a subscription is registered under: its dynamic
+// subscription-id if it has one, or a per-subscription synthetic key
+// otherwise (classic subscriptions share no server-assigned id).
+func (sub *Subscription) key() string {
+	if sub.id != "" {
+		return sub.id
+	}
+	return fmt.Sprintf("classic-%p", sub)
+}
+
+// Unsubscribe terminates sub: it deregisters it, issues a <delete-subscription>
+// RPC for a dynamic (RFC 8639) subscription or a <kill-subscription> RPC for a
+// classic (RFC 5277) one, and then closes its Notifications channel.
+func (sub *Subscription) Unsubscribe(ctx context.Context) error {
+	si := sub.si
+
+	si.subLock.Lock()
+	delete(si.subs, sub.key())
+	si.subLock.Unlock()
+
+	var req Request
+	if sub.id != "" {
+		req = Request(fmt.Sprintf(`<delete-subscription xmlns="%s"><id>%s</id></delete-subscription>`,
+			"urn:ietf:params:xml:ns:yang:ietf-subscribed-notifications", sub.id))
+	} else {
+		req = Request(fmt.Sprintf(`<kill-subscription xmlns="%s"/>`, netconfNotifyNS))
+	}
+
+	_, err := si.Execute(ctx, req)
+	close(sub.Notifications)
+	return err
+}
+
+// routeNotification delivers n to the subscription identified by subID, or,
+// if subID is empty, fans it out to every classic subscription currently
+// registered (see Subscribe for why classic subscriptions cannot be
+// demultiplexed precisely).
+//
+// It runs on the session's single decode-loop goroutine, so it must not
+// block while holding si.subLock: Unsubscribe needs that same lock to
+// deregister a subscription, and a consumer that stops draining
+// Notifications would otherwise wedge the lock against this call forever,
+// stalling delivery of every RPC reply and notification on the session, not
+// just this subscription's. Target channels are therefore collected while
+// the lock is held, then sent to afterwards, non-blocking (dropped and
+// logged rather than blocking delivery to the other targets, or to the next
+// notification, on one slow consumer).
+func (si *sesImpl) routeNotification(subID string, n *Notification) {
+	si.subLock.Lock()
+	var targets []chan *Notification
+	if subID != "" {
+		if sub, ok := si.subs[subID]; ok {
+			targets = []chan *Notification{sub.Notifications}
+		} else {
+			si.subLock.Unlock()
+			si.evtlog.Printf("notification for unknown subscription-id %q - dropped\n", subID)
+			return
+		}
+	} else {
+		for _, sub := range si.subs {
+			if sub.id == "" {
+				targets = append(targets, sub.Notifications)
+			}
+		}
+	}
+	si.subLock.Unlock()
+
+	for _, ch := range targets {
+		select {
+		case ch <- n:
+		default:
+			si.evtlog.Printf("subscription notification channel full or stalled - dropped %s notification\n", n.XMLName.Local)
+		}
+	}
+}
+
+// resubscribeAll reissues the create-subscription/establish-subscription RPC
+// for every subscription recorded on the session, e.g. after
+// ReconnectingTransport has redialed and renegotiated <hello>. A dynamic
+// (RFC 8639) subscription may be assigned a new id by the server; the
+// session's bookkeeping is updated to match. Subscriptions whose reissue
+// fails are logged, dropped and have their Notifications channel closed,
+// since there is no RPC reply channel left open for the original caller to
+// observe the failure on.
+func (si *sesImpl) resubscribeAll() {
+	si.subLock.Lock()
+	subs := make([]*Subscription, 0, len(si.subs))
+	for _, sub := range si.subs {
+		subs = append(subs, sub)
+	}
+	si.subs = make(map[string]*Subscription)
+	si.subLock.Unlock()
+
+	for _, sub := range subs {
+		if err := si.issueSubscribe(sub); err != nil {
+			si.evtlog.Printf("resubscribe failed for %s: %v\n", sub.req, err)
+			close(sub.Notifications)
+		}
+	}
+}
+
+func (si *sesImpl) closeAllSubscriptions() {
+	si.subLock.Lock()
+	defer si.subLock.Unlock()
+	for key, sub := range si.subs {
+		close(sub.Notifications)
+		delete(si.subs, key)
+	}
+}